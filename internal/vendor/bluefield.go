@@ -0,0 +1,106 @@
+package vendor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// bluefieldVendorDevices are BlueField DPU PF identifiers. Their
+// VF-equivalents are scalable functions (SFs) created on the auxiliary
+// bus rather than real PCI VFs (see containernetworking/plugins'
+// DeviceID-on-auxiliary-bus support for the same convention).
+var bluefieldVendorDevices = []string{
+	"15b3:a2d6", // BlueField-2 integrated ConnectX-6 Dx
+	"15b3:a2dc", // BlueField-3 integrated ConnectX-7
+}
+
+const auxDevicesPath = "/sys/bus/auxiliary/devices"
+
+// auxDeviceIDEnv, when set, is a filepath.Match glob (e.g.
+// "mlx5_core.sf.*" or "mlx5_core.sf.2") restricting which SFs Discover
+// returns; unset means every mlx5_core.sf.* device is in scope.
+const auxDeviceIDEnv = "AUX_DEVICE_ID"
+
+type bluefieldProvider struct{}
+
+func init() {
+	p := bluefieldProvider{}
+	for _, vd := range bluefieldVendorDevices {
+		Register(vd, p)
+	}
+}
+
+// Discover enumerates the mlx5_core.sf.<n> auxiliary devices whose parent
+// device is the PF at pciAddr, filtered by the AUX_DEVICE_ID env glob when
+// set, and returns them ordered by SF number.
+func (bluefieldProvider) Discover(pciAddr string) ([]string, error) {
+	entries, err := os.ReadDir(auxDevicesPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading auxiliary bus devices: %v", err)
+	}
+
+	filter := os.Getenv(auxDeviceIDEnv)
+	indexed := make(map[int]string)
+	maxIndex := -1
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, "mlx5_core.sf.") {
+			continue
+		}
+		if filter != "" {
+			if ok, err := filepath.Match(filter, name); err != nil || !ok {
+				continue
+			}
+		}
+		idx, err := strconv.Atoi(strings.TrimPrefix(name, "mlx5_core.sf."))
+		if err != nil {
+			continue
+		}
+		// An SF's auxiliary device sits directly under its parent PF's
+		// PCI device directory.
+		target, err := filepath.EvalSymlinks(filepath.Join(auxDevicesPath, name))
+		if err != nil || filepath.Base(filepath.Dir(target)) != pciAddr {
+			continue
+		}
+		indexed[idx] = filepath.Join(auxDevicesPath, name)
+		if idx > maxIndex {
+			maxIndex = idx
+		}
+	}
+
+	sfs := make([]string, 0, len(indexed))
+	for i := 0; i <= maxIndex; i++ {
+		if path, ok := indexed[i]; ok {
+			sfs = append(sfs, path)
+		}
+	}
+	return sfs, nil
+}
+
+// NumVFsPath returns "" because BlueField SFs are created individually
+// (via devlink port add) rather than by writing a VF count.
+func (bluefieldProvider) NumVFsPath(pciAddr string) string {
+	return ""
+}
+
+func (bluefieldProvider) VFPath(pciAddr string, idx int) (string, error) {
+	path := filepath.Join(auxDevicesPath, fmt.Sprintf("mlx5_core.sf.%d", idx))
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("SF %d not found: %v", idx, err)
+	}
+	if filepath.Base(filepath.Dir(target)) != pciAddr {
+		return "", fmt.Errorf("SF %d does not belong to PF %s", idx, pciAddr)
+	}
+	return path, nil
+}
+
+// RebindPolicy returns "" because BlueField SFs use the same mlx5 drivers
+// as their parent PF; there is no separate default the way there is for
+// handing PCI VFs to vfio-pci/uio_pci_generic.
+func (bluefieldProvider) RebindPolicy() string {
+	return ""
+}
@@ -0,0 +1,64 @@
+package vendor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// mlx5Provider implements the standard PCI SR-IOV virtfnN sysfs sequence
+// this tool was originally written against. It is registered as the
+// fallback default rather than under specific ConnectX vendor:device IDs,
+// since unrecognized PFs should keep behaving exactly as before this
+// package existed.
+type mlx5Provider struct{}
+
+func init() {
+	defaultProvider = mlx5Provider{}
+}
+
+func (p mlx5Provider) Discover(pciAddr string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(pciDevicesPath, pciAddr))
+	if err != nil {
+		return nil, fmt.Errorf("error reading PF device directory for %s: %v", pciAddr, err)
+	}
+
+	maxIndex := -1
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), "virtfn") {
+			continue
+		}
+		if idx, err := strconv.Atoi(strings.TrimPrefix(entry.Name(), "virtfn")); err == nil && idx > maxIndex {
+			maxIndex = idx
+		}
+	}
+
+	vfs := make([]string, 0, maxIndex+1)
+	for i := 0; i <= maxIndex; i++ {
+		path, err := p.VFPath(pciAddr, i)
+		if err != nil {
+			continue
+		}
+		vfs = append(vfs, path)
+	}
+	return vfs, nil
+}
+
+func (mlx5Provider) NumVFsPath(pciAddr string) string {
+	return filepath.Join(pciDevicesPath, pciAddr, "sriov_numvfs")
+}
+
+func (mlx5Provider) VFPath(pciAddr string, idx int) (string, error) {
+	link := filepath.Join(pciDevicesPath, pciAddr, fmt.Sprintf("virtfn%d", idx))
+	target, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %v", link, err)
+	}
+	return target, nil
+}
+
+func (mlx5Provider) RebindPolicy() string {
+	return "" // deferred to the tool's VF_DRIVER/policy configuration
+}
@@ -0,0 +1,112 @@
+// Package vendor recognizes a PF's PCI vendor:device and returns the sysfs
+// conventions needed to discover, count, and rebind its VFs or scalable
+// functions. Mellanox ConnectX cards follow the standard PCI SR-IOV
+// virtfnN sequence the rest of this tool was originally written against;
+// other NICs and DPUs expose VF-like functions differently, so each quirk
+// lives behind the Provider interface and is selected by vendor:device
+// rather than littering the main reconciliation path with vendor
+// conditionals.
+package vendor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pciDevicesPath is where every PCI device, PF or VF, shows up in sysfs.
+const pciDevicesPath = "/sys/bus/pci/devices"
+
+// Provider implements the sysfs conventions for discovering and managing
+// a PF's VFs or scalable functions.
+type Provider interface {
+	// Discover returns the sysfs device directory of every VF/SF
+	// currently present under the PF at pciAddr, ordered by index.
+	Discover(pciAddr string) ([]string, error)
+	// NumVFsPath returns the sysfs file that controls how many VFs/SFs
+	// pciAddr's PF exposes, or "" if this provider creates them
+	// individually rather than by count (e.g. BlueField SFs).
+	NumVFsPath(pciAddr string) string
+	// VFPath returns the sysfs device directory of the idx'th VF/SF under
+	// the PF at pciAddr.
+	VFPath(pciAddr string, idx int) (string, error)
+	// RebindPolicy returns the driver this provider's VFs/SFs should be
+	// bound to by default, or "" to defer to the tool's configured or
+	// kernel default.
+	RebindPolicy() string
+}
+
+var providers = map[string]Provider{}
+var defaultProvider Provider
+
+// Register associates a PCI "vendor:device" identifier (as read from
+// /sys/bus/pci/devices/<addr>/{vendor,device}) with a Provider. Called from
+// each implementation's init().
+func Register(vendorDevice string, p Provider) {
+	providers[vendorDevice] = p
+}
+
+// ReadVendorDevice returns pciAddr's "vendor:device" identifier, e.g.
+// "15b3:1017".
+func ReadVendorDevice(pciAddr string) (string, error) {
+	vendorID, err := readHexID(pciAddr, "vendor")
+	if err != nil {
+		return "", err
+	}
+	deviceID, err := readHexID(pciAddr, "device")
+	if err != nil {
+		return "", err
+	}
+	return vendorID + ":" + deviceID, nil
+}
+
+func readHexID(pciAddr, attr string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(pciDevicesPath, pciAddr, attr))
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s %s: %v", pciAddr, attr, err)
+	}
+	return strings.TrimPrefix(strings.TrimSpace(string(data)), "0x"), nil
+}
+
+// For returns the Provider registered for pciAddr's vendor:device, falling
+// back to the default (Mellanox ConnectX) provider if none matches.
+func For(pciAddr string) (Provider, error) {
+	vendorDevice, err := ReadVendorDevice(pciAddr)
+	if err != nil {
+		return nil, err
+	}
+	if p, ok := providers[vendorDevice]; ok {
+		return p, nil
+	}
+	return defaultProvider, nil
+}
+
+// DiscoverPFs returns the PCI addresses of every physical function on the
+// bus whose vendor:device has an explicitly registered Provider - i.e. PFs
+// that getHCAs' infiniband-rooted walk never sees, such as Yusur DPUs and
+// BlueField's scalable-function parents. Mellanox ConnectX PFs are not
+// included here since they're already reached via the infiniband flow and
+// only occupy the default (unregistered) provider slot.
+func DiscoverPFs() ([]string, error) {
+	entries, err := os.ReadDir(pciDevicesPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", pciDevicesPath, err)
+	}
+
+	var pfs []string
+	for _, entry := range entries {
+		addr := entry.Name()
+		if _, err := os.Lstat(filepath.Join(pciDevicesPath, addr, "physfn")); err == nil {
+			continue // has a physfn symlink, so this is a VF, not a PF
+		}
+		vendorDevice, err := ReadVendorDevice(addr)
+		if err != nil {
+			continue
+		}
+		if _, ok := providers[vendorDevice]; ok {
+			pfs = append(pfs, addr)
+		}
+	}
+	return pfs, nil
+}
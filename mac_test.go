@@ -0,0 +1,84 @@
+package main
+
+import "testing"
+
+func TestDeriveOffsetMAC(t *testing.T) {
+	cases := []struct {
+		pfMAC   string
+		vfIndex int
+		want    string
+	}{
+		{"00:11:22:33:44:55", 0, "02:11:22:33:44:55"},
+		{"00:11:22:33:44:55", 1, "02:11:22:33:44:56"},
+		{"00:11:22:33:44:ff", 1, "02:11:22:33:44:00"},
+	}
+	for _, c := range cases {
+		got, err := deriveOffsetMAC(c.pfMAC, c.vfIndex)
+		if err != nil {
+			t.Fatalf("deriveOffsetMAC(%q, %d): unexpected error: %v", c.pfMAC, c.vfIndex, err)
+		}
+		if got != c.want {
+			t.Errorf("deriveOffsetMAC(%q, %d) = %q, want %q", c.pfMAC, c.vfIndex, got, c.want)
+		}
+	}
+}
+
+func TestDeriveOffsetMACInvalid(t *testing.T) {
+	if _, err := deriveOffsetMAC("not-a-mac", 0); err == nil {
+		t.Fatal("expected error for malformed PF MAC, got nil")
+	}
+}
+
+func TestDeriveHashMACDeterministic(t *testing.T) {
+	mac1, err := deriveHashMAC("mlx5_0", 3)
+	if err != nil {
+		t.Fatalf("deriveHashMAC: unexpected error: %v", err)
+	}
+	mac2, err := deriveHashMAC("mlx5_0", 3)
+	if err != nil {
+		t.Fatalf("deriveHashMAC: unexpected error: %v", err)
+	}
+	if mac1 != mac2 {
+		t.Errorf("deriveHashMAC is not deterministic: %q != %q", mac1, mac2)
+	}
+	if mac1[:2] != "02" {
+		t.Errorf("deriveHashMAC MAC %q is not locally administered", mac1)
+	}
+}
+
+func TestDeriveHashMACVariesByInput(t *testing.T) {
+	mac1, err := deriveHashMAC("mlx5_0", 0)
+	if err != nil {
+		t.Fatalf("deriveHashMAC: unexpected error: %v", err)
+	}
+	mac2, err := deriveHashMAC("mlx5_0", 1)
+	if err != nil {
+		t.Fatalf("deriveHashMAC: unexpected error: %v", err)
+	}
+	mac3, err := deriveHashMAC("mlx5_1", 0)
+	if err != nil {
+		t.Fatalf("deriveHashMAC: unexpected error: %v", err)
+	}
+	if mac1 == mac2 {
+		t.Errorf("deriveHashMAC(%q, 0) == deriveHashMAC(%q, 1): %q", "mlx5_0", "mlx5_0", mac1)
+	}
+	if mac1 == mac3 {
+		t.Errorf("deriveHashMAC(%q, 0) == deriveHashMAC(%q, 0): %q", "mlx5_0", "mlx5_1", mac1)
+	}
+}
+
+func TestLookupExplicitMAC(t *testing.T) {
+	macMap := map[string]string{
+		"mlx5_0/2": "02:00:00:00:00:02",
+		"3":        "02:00:00:00:00:ff",
+	}
+	if mac, err := lookupExplicitMAC(macMap, "mlx5_0", 2); err != nil || mac != "02:00:00:00:00:02" {
+		t.Errorf("lookupExplicitMAC(hca/vf key) = %q, %v, want exact match", mac, err)
+	}
+	if mac, err := lookupExplicitMAC(macMap, "mlx5_1", 3); err != nil || mac != "02:00:00:00:00:ff" {
+		t.Errorf("lookupExplicitMAC(vf-only fallback) = %q, %v, want fallback match", mac, err)
+	}
+	if _, err := lookupExplicitMAC(macMap, "mlx5_1", 9); err == nil {
+		t.Error("expected error for unmapped hca/vfIndex, got nil")
+	}
+}
@@ -1,133 +1,1252 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/Apsu/setup-sriov-vfs/internal/vendor"
 )
 
 const infinibandBasePath = "/sys/class/infiniband"
 
-func main() {
-	// Read configuration from environment variables.
-	numVFsStr := os.Getenv("NUM_VFS")
-	if numVFsStr == "" {
-		fmt.Fprintln(os.Stderr, "NUM_VFS environment variable is not set.")
-		os.Exit(1)
+// pciDevicesBasePath is where every PCI device, PF or VF, shows up in
+// sysfs. It's a var rather than a const so tests can point it at a fake
+// sysfs tree.
+var pciDevicesBasePath = "/sys/bus/pci/devices"
+
+// logger is used for all operational log output (everything except
+// --status's JSON result, which must stay on stdout unadorned). It writes
+// structured JSON lines to stderr so log aggregators can filter/group by
+// the "hca" field each call site attaches.
+var logger = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+
+// modeEnv selects daemon mode as an alternative to --daemon. Defaults to
+// running once and exiting.
+const modeEnv = "MODE"
+
+const modeDaemon = "daemon"
+
+// metricsAddrEnv sets the address the Prometheus metrics endpoint listens
+// on in daemon mode. Defaults to metricsAddrDefault.
+const metricsAddrEnv = "METRICS_ADDR"
+const metricsAddrDefault = ":9110"
+
+// reconcileDebounce coalesces bursts of sysfs inotify events (a PF
+// hotplug typically touches several files at once) into a single
+// reconciliation pass.
+const reconcileDebounce = 2 * time.Second
+
+// macStrategyEnv selects how VF MAC addresses are derived. Defaults to
+// "offset" to preserve existing behavior. It does not apply to VF GUIDs on
+// InfiniBand HCAs, which assignVFGuids always derives by offset.
+const macStrategyEnv = "MAC_STRATEGY"
+
+const (
+	macStrategyOffset   = "offset"
+	macStrategyHash     = "hash"
+	macStrategyExplicit = "explicit"
+)
+
+// eswitchModeEnv selects the PF's eswitch mode. Defaults to "legacy" (no
+// change), matching the tool's behavior before switchdev support existed.
+const eswitchModeEnv = "ESWITCH_MODE"
+
+const (
+	eswitchModeLegacy    = "legacy"
+	eswitchModeSwitchdev = "switchdev"
+)
+
+// encapModeEnv configures the eswitch encap knob once ESWITCH_MODE=switchdev
+// is in effect. Defaults to leaving the driver's own default in place.
+const encapModeEnv = "ENCAP_MODE"
+
+// representorWaitAttempts/representorWaitInterval bound how long we poll
+// for representor netdevs to appear after enabling switchdev mode.
+const (
+	representorWaitAttempts = 10
+	representorWaitInterval = 500 * time.Millisecond
+)
+
+// rebindPolicyEnv selects when VF drivers are (re)bound. Defaults to
+// "auto", which only rebinds when RDMA is in use. It applies to the VFs of
+// infiniband-enumerated HCAs only; configureVendorPFs rebinds every VF/SF
+// of a vendor-specific PF regardless of it.
+const rebindPolicyEnv = "REBIND"
+
+const (
+	rebindPolicyAuto   = "auto"
+	rebindPolicyAlways = "always"
+	rebindPolicyNever  = "never"
+)
+
+// vfDriverEnv, when set, binds every VF to this driver (e.g. "vfio-pci",
+// "uio_pci_generic") instead of leaving/returning it on its current one.
+const vfDriverEnv = "VF_DRIVER"
+
+func main() {
+	configPath := flag.String("config", "", "path to a JSON policy config file (falls back to NUM_VFS/DEVICE_ID and friends)")
+	dryRun := flag.Bool("dry-run", false, "print planned sysfs writes instead of executing them")
+	statusMode := flag.Bool("status", false, "print current HCA/VF state as JSON and exit")
+	daemonMode := flag.Bool("daemon", strings.EqualFold(os.Getenv(modeEnv), modeDaemon), "keep running and re-reconcile on PF/VF sysfs changes, serving Prometheus metrics")
+	metricsAddr := flag.String("metrics-addr", envOrDefault(metricsAddrEnv, metricsAddrDefault), "address for the Prometheus metrics endpoint in daemon mode")
+	flag.Parse()
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		logger.Error("failed to load configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if *daemonMode {
+		// Unlike the one-shot modes below, the daemon's whole purpose is to
+		// outlive an empty or not-yet-present infinibandBasePath (e.g. a PF
+		// hotplugged after startup), so it does its own HCA discovery rather
+		// than exiting when none are found yet.
+		runDaemon(cfg, *dryRun, *metricsAddr)
+		return
+	}
+
+	// Retrieve all HCA entries from infinibandBasePath.
+	hcas, err := getHCAs(infinibandBasePath)
+	if err != nil {
+		logger.Error("failed to read HCAs", "error", err)
+		os.Exit(1)
+	}
+	if len(hcas) == 0 {
+		logger.Error("no HCAs found", "path", infinibandBasePath)
+		os.Exit(1)
+	}
+
+	if *statusMode {
+		if err := printStatus(hcas); err != nil {
+			logger.Error("failed to collect status", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	reconcileAll(hcas, cfg, *dryRun)
+	logger.Info("SR-IOV VF configuration completed for all matching HCAs")
+}
+
+// envOrDefault returns the value of the environment variable key, or def
+// if it is unset or empty.
+func envOrDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// reconcileAll matches and applies policies from cfg against every HCA in
+// hcas, then does the same for any vendor-specific PFs (Yusur DPUs,
+// BlueField SF parents) getHCAs' infiniband-rooted walk doesn't reach,
+// recording Prometheus metrics for each outcome.
+func reconcileAll(hcas []string, cfg *Config, dryRun bool) {
+	for _, hca := range hcas {
+		pciAddr, err := getPFPCIAddr(hca)
+		if err != nil {
+			logger.Error("failed to resolve PF PCI address", "hca", hca, "error", err)
+			continue
+		}
+
+		policy, err := cfg.policyFor(hca, pciAddr)
+		if err != nil {
+			logger.Error("failed to match policy", "hca", hca, "error", err)
+			continue
+		}
+		if policy == nil {
+			logger.Info("skipping HCA, no matching policy", "hca", hca)
+			continue
+		}
+
+		recordPFInfo(hca, pciAddr)
+
+		logger.Info("configuring HCA", "hca", hca)
+		if err := configureHCA(hca, *policy, dryRun); err != nil {
+			logger.Error("failed to configure HCA", "hca", hca, "error", err)
+			metrics.configureErrors.inc(hca, "configure")
+			continue
+		}
+		if !dryRun {
+			metrics.lastReconcile.set(hca)
+		}
+	}
+
+	configureVendorPFs(cfg, dryRun)
+}
+
+// configureVendorPFs discovers and reconciles PFs recognized by a
+// vendor-specific Provider (Yusur DPUs, BlueField scalable-function
+// parents) that live outside the infiniband-enumerated flow above,
+// matching each one against cfg via PolicyMatch just like an HCA (with ""
+// standing in for the infiniband HCA name these PFs don't have).
+func configureVendorPFs(cfg *Config, dryRun bool) {
+	pfs, err := vendor.DiscoverPFs()
+	if err != nil {
+		logger.Warn("failed to discover vendor-specific PFs", "error", err)
+		return
+	}
+
+	for _, pf := range pfs {
+		policy, err := cfg.policyFor("", pf)
+		if err != nil {
+			logger.Error("failed to match policy", "pci", pf, "error", err)
+			continue
+		}
+		if policy == nil {
+			logger.Info("skipping vendor PF, no matching policy", "pci", pf)
+			continue
+		}
+
+		if vd, err := vendor.ReadVendorDevice(pf); err == nil {
+			vendorID, deviceID, _ := strings.Cut(vd, ":")
+			// These PFs have no infiniband port to read link layer from.
+			metrics.pfInfo.set(pf, pf, vendorID, deviceID, "unknown")
+		}
+
+		provider, err := vendor.For(pf)
+		if err != nil {
+			logger.Warn("failed to identify vendor provider", "pci", pf, "error", err)
+			continue
+		}
+
+		if numVFsPath := provider.NumVFsPath(pf); numVFsPath != "" {
+			if _, err := reconcileWrite(numVFsPath, strconv.Itoa(policy.NumVFs), dryRun); err != nil {
+				logger.Warn("failed to reconcile VF count", "pci", pf, "path", numVFsPath, "error", err)
+				metrics.configureErrors.inc(pf, "numvfs")
+				continue
+			}
+		}
+
+		vfs, err := provider.Discover(pf)
+		if err != nil {
+			logger.Warn("failed to discover VFs/SFs", "pci", pf, "error", err)
+			metrics.configureErrors.inc(pf, "discover")
+			continue
+		}
+
+		// MAC assignment uses the PF-relative "sriov/<idx>/mac" sysfs
+		// attribute, which only exists for providers (mlx5, Yusur) whose
+		// VFs are real PCI SR-IOV functions; BlueField SFs have no such
+		// attribute and are skipped (NumVFsPath == "" identifies them).
+		var pfMAC string
+		if provider.NumVFsPath(pf) != "" {
+			pfMAC, err = getPCIPFMac(pf)
+			if err != nil {
+				logger.Warn("failed to read PF MAC, skipping VF MAC assignment", "pci", pf, "error", err)
+			}
+		}
+
+		targetDriver := provider.RebindPolicy()
+		if targetDriver == "" {
+			targetDriver = os.Getenv(vfDriverEnv)
+		}
+
+		for i, vfPath := range vfs {
+			if pfMAC != "" {
+				assignVendorMAC(pf, pfMAC, i, dryRun)
+			}
+
+			if dryRun {
+				logger.Info("DRY-RUN: would rebind VF/SF", "pci", pf, "index", i, "driver", targetDriver)
+				continue
+			}
+			report, err := rebindDevice(vfPath, targetDriver)
+			if err != nil {
+				logger.Warn("failed to rebind VF/SF", "pci", pf, "index", i, "error", err)
+				metrics.configureErrors.inc(pf, "rebind")
+				continue
+			}
+			logger.Info("rebound VF/SF driver", "pci", pf, "index", i, "oldDriver", report.OldDriver, "newDriver", report.NewDriver)
+			metrics.vfDriverInfo.set(report.PCI, report.NewDriver, pf, strconv.Itoa(i))
+		}
+	}
+}
+
+// assignVendorMAC derives an offset MAC for the idx'th VF under the PF at
+// pf and writes it to the PF-relative "sriov/<idx>/mac" sysfs attribute -
+// the same convention assignVFMacs uses for infiniband-rooted HCAs, since
+// Yusur's DPU firmware exposes per-VF MAC the same way ConnectX does. Only
+// called for providers whose VFs are real PCI SR-IOV functions (see
+// configureVendorPFs).
+func assignVendorMAC(pf, pfMAC string, idx int, dryRun bool) {
+	vfMAC, err := deriveOffsetMAC(pfMAC, idx)
+	if err != nil {
+		logger.Warn("failed to derive VF MAC", "pci", pf, "index", idx, "error", err)
+		return
+	}
+	macPath := filepath.Join(pciDevicesBasePath, pf, "sriov", strconv.Itoa(idx), "mac")
+	changed, err := reconcileWrite(macPath, vfMAC, dryRun)
+	if err != nil {
+		logger.Warn("failed to write VF MAC", "pci", pf, "index", idx, "path", macPath, "error", err)
+		return
+	}
+	if changed {
+		logger.Info("assigned VF MAC", "pci", pf, "index", idx, "mac", vfMAC)
+		metrics.vfConfigured.inc(pf)
+	} else {
+		logger.Info("VF MAC already correct, skipping", "pci", pf, "index", idx, "mac", vfMAC)
+	}
+}
+
+// recordPFInfo populates the sriov_pf_info gauge for hca; failures are
+// non-fatal since this is best-effort observability.
+func recordPFInfo(hca, pciAddr string) {
+	vendorDevice, err := getVendorDeviceForPCI(pciAddr)
+	if err != nil {
+		return
+	}
+	linkLayer, err := getLinkLayer(hca)
+	if err != nil {
+		linkLayer = "unknown"
+	}
+	vendorID, deviceID, _ := strings.Cut(vendorDevice, ":")
+	metrics.pfInfo.set(hca, pciAddr, vendorID, deviceID, linkLayer)
+}
+
+// runDaemon serves Prometheus metrics and keeps reconciling every HCA in
+// infinibandBasePath against cfg, re-running reconciliation whenever an
+// entry directly inside infinibandBasePath or /sys/bus/pci changes (e.g.
+// PF hotplug); see inotifyMask for what does and does not raise an event.
+func runDaemon(cfg *Config, dryRun bool, metricsAddr string) {
+	go serveMetrics(metricsAddr)
+
+	hcas, err := getHCAs(infinibandBasePath)
+	if err != nil {
+		logger.Error("failed to read HCAs", "error", err)
+	} else {
+		reconcileAll(hcas, cfg, dryRun)
+	}
+
+	events, err := watchSysfs(infinibandBasePath, "/sys/bus/pci")
+	if err != nil {
+		logger.Error("failed to watch sysfs for changes; daemon will not re-reconcile on hotplug", "error", err)
+		select {}
+	}
+
+	for range debounce(events, reconcileDebounce) {
+		hcas, err := getHCAs(infinibandBasePath)
+		if err != nil {
+			logger.Error("failed to re-read HCAs", "error", err)
+			continue
+		}
+		logger.Info("sysfs change detected, re-reconciling")
+		reconcileAll(hcas, cfg, dryRun)
+	}
+}
+
+// debounce coalesces bursts of values on in (a single PF hotplug or
+// driver rebind typically fires several inotify events) into a single
+// value sent at most once per window, on the trailing edge of the burst.
+func debounce(in <-chan struct{}, window time.Duration) <-chan struct{} {
+	out := make(chan struct{})
+	go func() {
+		var timer *time.Timer
+		var fire <-chan time.Time
+		for {
+			select {
+			case _, ok := <-in:
+				if !ok {
+					return
+				}
+				if timer == nil {
+					timer = time.NewTimer(window)
+				} else {
+					timer.Reset(window)
+				}
+				fire = timer.C
+			case <-fire:
+				fire = nil
+				out <- struct{}{}
+			}
+		}
+	}()
+	return out
+}
+
+// inotifyMask watches for entries being created, deleted, moved or having
+// their attributes changed directly inside a watched directory (e.g. an
+// HCA appearing under infinibandBasePath on PF hotplug). inotify watches
+// are not recursive, so changes further down the tree, such as a device's
+// "driver" or "virtfnN" symlinks changing on driver (un)bind, raise no
+// event.
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM | syscall.IN_ATTRIB
+
+// watchSysfs watches each of paths via inotify and returns a channel that
+// receives a value per event observed. The channel is never closed; the
+// watch runs for the lifetime of the process.
+func watchSysfs(paths ...string) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit()
+	if err != nil {
+		return nil, fmt.Errorf("inotify_init: %v", err)
+	}
+	watching := 0
+	for _, p := range paths {
+		if _, err := syscall.InotifyAddWatch(fd, p, inotifyMask); err != nil {
+			logger.Warn("failed to watch path for changes", "path", p, "error", err)
+			continue
+		}
+		watching++
+	}
+	if watching == 0 {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("no watchable paths among %v", paths)
+	}
+
+	events := make(chan struct{})
+	go func() {
+		file := os.NewFile(uintptr(fd), "inotify")
+		defer file.Close()
+		buf := make([]byte, 4096)
+		for {
+			n, err := file.Read(buf)
+			if err != nil {
+				logger.Error("inotify read failed, stopping sysfs watch", "error", err)
+				return
+			}
+			offset := 0
+			for offset+syscall.SizeofInotifyEvent <= n {
+				raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				offset += syscall.SizeofInotifyEvent + int(raw.Len)
+				events <- struct{}{}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// serveMetrics blocks serving the Prometheus text exposition format on
+// addr at /metrics.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metrics.handler)
+	logger.Info("serving Prometheus metrics", "addr", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		logger.Error("metrics server stopped", "addr", addr, "error", err)
+	}
+}
+
+// metrics is the process-wide Prometheus registry. It's a minimal
+// hand-rolled implementation (text exposition format only) rather than a
+// pulled-in client library, to keep this tool dependency-free like the
+// rest of the codebase.
+var metrics = newMetricsRegistry()
+
+// metricsRegistry holds every metric this tool exposes on /metrics.
+type metricsRegistry struct {
+	vfConfigured    *counterVec // sriov_vf_configured_total{hca}
+	configureErrors *counterVec // sriov_vf_configure_errors_total{hca,step}
+	lastReconcile   *gaugeVec   // sriov_vf_last_reconcile_timestamp_seconds{hca}
+	vfDriverInfo    *infoVec    // sriov_vf_driver_info{pci,driver,hca,vf_index}
+	pfInfo          *infoVec    // sriov_pf_info{hca,pci,vendor,device,link_layer}
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		vfConfigured: newCounterVec("sriov_vf_configured_total",
+			"Total VFs successfully configured (MAC/GUID assigned).", "hca"),
+		configureErrors: newCounterVec("sriov_vf_configure_errors_total",
+			"Total configuration errors, by step.", "hca", "step"),
+		lastReconcile: newGaugeVec("sriov_vf_last_reconcile_timestamp_seconds",
+			"Unix timestamp of the last successful reconcile.", "hca"),
+		vfDriverInfo: newInfoVec("sriov_vf_driver_info",
+			"VF PCI-to-driver binding; value is always 1.", "pci", "driver", "hca", "vf_index"),
+		pfInfo: newInfoVec("sriov_pf_info",
+			"PF identity; value is always 1.", "hca", "pci", "vendor", "device", "link_layer"),
+	}
+}
+
+// counterVec is a monotonically increasing Prometheus counter keyed by a
+// fixed set of label values.
+type counterVec struct {
+	name, help string
+	labels     []string
+	mu         sync.Mutex
+	values     map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+func (c *counterVec) inc(labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[strings.Join(labelValues, "\x00")]++
+}
+
+func (c *counterVec) write(w http.ResponseWriter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for key, v := range c.values {
+		fmt.Fprintf(w, "%s{%s} %v\n", c.name, labelSet(c.labels, key), v)
+	}
+}
+
+// gaugeVec is a Prometheus gauge keyed by a fixed set of label values.
+type gaugeVec struct {
+	name, help string
+	labels     []string
+	mu         sync.Mutex
+	values     map[string]float64
+}
+
+func newGaugeVec(name, help string, labels ...string) *gaugeVec {
+	return &gaugeVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+func (g *gaugeVec) set(labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[strings.Join(labelValues, "\x00")] = float64(time.Now().Unix())
+}
+
+func (g *gaugeVec) write(w http.ResponseWriter) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	for key, v := range g.values {
+		fmt.Fprintf(w, "%s{%s} %v\n", g.name, labelSet(g.labels, key), v)
+	}
+}
+
+// infoVec is a Prometheus gauge whose value is always 1; its label values
+// carry the information (e.g. a PCI/driver/HCA tuple).
+type infoVec struct {
+	name, help string
+	labels     []string
+	mu         sync.Mutex
+	seen       map[string]struct{}
+}
+
+func newInfoVec(name, help string, labels ...string) *infoVec {
+	return &infoVec{name: name, help: help, labels: labels, seen: make(map[string]struct{})}
+}
+
+func (iv *infoVec) set(labelValues ...string) {
+	iv.mu.Lock()
+	defer iv.mu.Unlock()
+	iv.seen[strings.Join(labelValues, "\x00")] = struct{}{}
+}
+
+func (iv *infoVec) write(w http.ResponseWriter) {
+	iv.mu.Lock()
+	defer iv.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", iv.name, iv.help, iv.name)
+	for key := range iv.seen {
+		fmt.Fprintf(w, "%s{%s} 1\n", iv.name, labelSet(iv.labels, key))
+	}
+}
+
+// labelSet renders a \x00-joined label-value key back into Prometheus
+// "name=\"value\",..." form alongside the metric's fixed label names.
+func labelSet(labels []string, key string) string {
+	values := strings.Split(key, "\x00")
+	parts := make([]string, len(labels))
+	for i, name := range labels {
+		parts[i] = fmt.Sprintf("%s=%q", name, values[i])
+	}
+	return strings.Join(parts, ",")
+}
+
+// handler renders every metric in the Prometheus text exposition format.
+func (m *metricsRegistry) handler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.vfConfigured.write(w)
+	m.configureErrors.write(w)
+	m.lastReconcile.write(w)
+	m.vfDriverInfo.write(w)
+	m.pfInfo.write(w)
+}
+
+// PolicyMatch selects which HCAs a Policy applies to. An empty PolicyMatch
+// matches every HCA. DeviceID preserves the legacy DEVICE_ID env semantics
+// (an exact match against the PF's sysfs "device" file); VendorDevice,
+// HCAGlob, and PCIPrefix are the richer selectors a --config file can use.
+type PolicyMatch struct {
+	DeviceID     string `json:"deviceId,omitempty"`
+	VendorDevice string `json:"vendorDevice,omitempty"`
+	HCAGlob      string `json:"hcaGlob,omitempty"`
+	PCIPrefix    string `json:"pciPrefix,omitempty"`
+}
+
+// matches reports whether the PF at pciAddr (with infiniband HCA name hca,
+// or "" for vendor-specific PFs with no infiniband device) satisfies every
+// selector set on m. Unset selectors are ignored, so a zero-value
+// PolicyMatch matches everything. HCAGlob is infiniband-name-specific, so
+// it never matches when hca is "".
+func (m PolicyMatch) matches(hca, pciAddr string) (bool, error) {
+	if m.DeviceID != "" {
+		ok, err := checkDeviceIdForPCI(pciAddr, m.DeviceID)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	if m.HCAGlob != "" {
+		if hca == "" {
+			return false, nil
+		}
+		ok, err := filepath.Match(m.HCAGlob, hca)
+		if err != nil {
+			return false, fmt.Errorf("invalid hcaGlob %q: %v", m.HCAGlob, err)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	if m.VendorDevice != "" {
+		vd, err := getVendorDeviceForPCI(pciAddr)
+		if err != nil {
+			return false, err
+		}
+		if vd != m.VendorDevice {
+			return false, nil
+		}
+	}
+	if m.PCIPrefix != "" {
+		if !strings.HasPrefix(pciAddr, m.PCIPrefix) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// MACPolicy configures how a Policy derives VF MAC addresses; see
+// macStrategyEnv for the available strategies (VF GUIDs are unaffected).
+type MACPolicy struct {
+	Strategy string `json:"strategy,omitempty"`
+}
+
+// Policy describes the desired SR-IOV VF state for every HCA its Match
+// selects. Trust and Spoofchk are pointers so "unset" (leave as-is) can be
+// distinguished from "false".
+type Policy struct {
+	Match       PolicyMatch `json:"match,omitempty"`
+	NumVFs      int         `json:"numVFs"`
+	LinkType    string      `json:"linkType,omitempty"`
+	MTU         int         `json:"mtu,omitempty"`
+	VFDriver    string      `json:"vfDriver,omitempty"`
+	MAC         MACPolicy   `json:"mac,omitempty"`
+	EswitchMode string      `json:"eswitchMode,omitempty"`
+	Trust       *bool       `json:"trust,omitempty"`
+	Spoofchk    *bool       `json:"spoofchk,omitempty"`
+}
+
+// Config is the top-level shape of a --config file: a list of Policies,
+// evaluated in order, with the first matching one applied to each HCA.
+type Config struct {
+	Policies []Policy `json:"policies"`
+}
+
+// policyFor returns the first Policy in c whose Match selects the PF
+// identified by hca (its infiniband HCA name, or "" for vendor-specific
+// PFs with no infiniband device) and pciAddr, or nil if none do.
+func (c *Config) policyFor(hca, pciAddr string) (*Policy, error) {
+	for i := range c.Policies {
+		matched, err := c.Policies[i].Match.matches(hca, pciAddr)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			return &c.Policies[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// loadConfig reads policies from path if given, otherwise synthesizes a
+// single catch-all Policy from the legacy NUM_VFS/DEVICE_ID/MAC_STRATEGY/
+// etc. environment variables, so existing deployments keep working
+// unchanged.
+func loadConfig(path string) (*Config, error) {
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading config file %s: %v", path, err)
+		}
+		var cfg Config
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid config JSON in %s: %v", path, err)
+		}
+		for i := range cfg.Policies {
+			if cfg.Policies[i].NumVFs <= 0 {
+				return nil, fmt.Errorf("policy %d in %s: numVFs must be set and > 0 (got %d)", i, path, cfg.Policies[i].NumVFs)
+			}
+		}
+		return &cfg, nil
+	}
+	return configFromEnv()
+}
+
+// configFromEnv reproduces the tool's original flat env-var interface as a
+// single-policy Config.
+func configFromEnv() (*Config, error) {
+	numVFsStr := os.Getenv("NUM_VFS")
+	if numVFsStr == "" {
+		return nil, fmt.Errorf("NUM_VFS environment variable is not set (or pass --config)")
+	}
+	numVFs, err := strconv.Atoi(numVFsStr)
+	if err != nil || numVFs <= 0 {
+		return nil, fmt.Errorf("invalid NUM_VFS value: %s", numVFsStr)
+	}
+
+	policy := Policy{
+		Match:       PolicyMatch{DeviceID: os.Getenv("DEVICE_ID")},
+		NumVFs:      numVFs,
+		VFDriver:    os.Getenv(vfDriverEnv),
+		EswitchMode: os.Getenv(eswitchModeEnv),
+		MAC:         MACPolicy{Strategy: os.Getenv(macStrategyEnv)},
+	}
+	return &Config{Policies: []Policy{policy}}, nil
+}
+
+// getVendorDevice returns the "<vendor>:<device>" sysfs ID pair for hca's
+// PF, e.g. "15b3:1018". The 0x prefix sysfs reports is stripped so the
+// format matches vendor.ReadVendorDevice (the vendor-PF equivalent) and
+// the vendorDevice IDs registered in internal/vendor - this is what
+// PolicyMatch.VendorDevice and --status/metrics compare against.
+func getVendorDevice(hca string) (string, error) {
+	return getVendorDeviceAt(filepath.Join(infinibandBasePath, hca, "device"))
+}
+
+// getVendorDeviceForPCI is getVendorDevice for a PF identified by bare PCI
+// address rather than infiniband HCA name, used for PolicyMatch and status
+// reporting on vendor-specific PFs that have no infiniband device.
+func getVendorDeviceForPCI(pciAddr string) (string, error) {
+	return getVendorDeviceAt(filepath.Join(pciDevicesBasePath, pciAddr))
+}
+
+func getVendorDeviceAt(devDir string) (string, error) {
+	vendorID, err := os.ReadFile(filepath.Join(devDir, "vendor"))
+	if err != nil {
+		return "", fmt.Errorf("error reading vendor ID from %s: %v", devDir, err)
+	}
+	deviceID, err := os.ReadFile(filepath.Join(devDir, "device"))
+	if err != nil {
+		return "", fmt.Errorf("error reading device ID from %s: %v", devDir, err)
+	}
+	return fmt.Sprintf("%s:%s", trimHexID(vendorID), trimHexID(deviceID)), nil
+}
+
+// trimHexID strips the whitespace and "0x" prefix sysfs vendor/device ID
+// files carry, e.g. "0x15b3\n" -> "15b3".
+func trimHexID(raw []byte) string {
+	return strings.TrimPrefix(strings.TrimSpace(string(raw)), "0x")
+}
+
+// getPFPCIAddr resolves hca's PF PCI bus address by following the
+// "device" symlink under its infiniband sysfs directory.
+func getPFPCIAddr(hca string) (string, error) {
+	devDir := filepath.Join(infinibandBasePath, hca, "device")
+	target, err := filepath.EvalSymlinks(devDir)
+	if err != nil {
+		return "", fmt.Errorf("error resolving PF PCI address for %s: %v", hca, err)
+	}
+	return filepath.Base(target), nil
+}
+
+// reconcileWrite writes value to path only if its current contents
+// differ, so repeated runs of the same policy are a no-op. In dry-run
+// mode it reports what it would have written instead of touching sysfs.
+// It returns whether a write was (or would have been) needed.
+func reconcileWrite(path, value string, dryRun bool) (bool, error) {
+	if current, err := os.ReadFile(path); err == nil && strings.TrimSpace(string(current)) == value {
+		return false, nil
+	}
+	if dryRun {
+		logger.Info("DRY-RUN: would write sysfs attribute", "path", path, "value", value)
+		return true, nil
+	}
+	if err := os.WriteFile(path, []byte(value), 0644); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// getHCAs lists all entries in infinibandBasePath and, for each,
+// uses os.Stat to follow symlinks and verify the target is a directory.
+func getHCAs(basePath string) ([]string, error) {
+	entries, err := os.ReadDir(basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var hcas []string
+	for _, entry := range entries {
+		fullPath := filepath.Join(basePath, entry.Name())
+		info, err := os.Stat(fullPath) // follows symlinks
+		if err != nil {
+			logger.Warn("could not stat entry", "path", fullPath, "error", err)
+			continue
+		}
+		if info.IsDir() {
+			hcas = append(hcas, entry.Name())
+		}
+	}
+	return hcas, nil
+}
+
+// checkDeviceIdForPCI reads the device ID for the PF at pciAddr and
+// compares it to expectedDeviceID.
+func checkDeviceIdForPCI(pciAddr, expectedDeviceID string) (bool, error) {
+	devicePath := filepath.Join(pciDevicesBasePath, pciAddr, "device")
+	data, err := os.ReadFile(devicePath)
+	if err != nil {
+		return false, err
+	}
+	id := strings.TrimSpace(string(data))
+	return id == expectedDeviceID, nil
+}
+
+// configureHCA reconciles a single HCA against policy, only touching
+// sysfs attributes that are not already in the desired state.
+func configureHCA(hca string, policy Policy, dryRun bool) error {
+	numVFs := policy.NumVFs
+
+	// Optionally place the PF into switchdev eswitch mode before creating
+	// any VFs; switchdev and the eswitch mode itself cannot be changed
+	// while VFs already exist.
+	switchdevActive, err := configureEswitchMode(hca, policy.EswitchMode, dryRun)
+	if err != nil {
+		return fmt.Errorf("failed to configure eswitch mode: %v", err)
+	}
+
+	if err := reconcileSriovNumVFs(hca, numVFs, dryRun); err != nil {
+		return fmt.Errorf("failed to reconcile sriov_numvfs: %v", err)
+	}
+
+	// InfiniBand HCAs address VFs with GUIDs rather than MAC addresses;
+	// Ethernet HCAs (and IB HCAs running in Ethernet link mode) use MACs.
+	// policy.LinkType overrides autodetection when set.
+	linkLayer, err := resolveLinkLayer(hca, policy.LinkType)
+	if err != nil {
+		logger.Warn("could not determine link layer, assuming Ethernet", "hca", hca, "error", err)
+		linkLayer = "Ethernet"
+	}
+
+	if strings.EqualFold(linkLayer, "InfiniBand") {
+		pfGUID, err := getPFGUID(hca)
+		if err != nil {
+			return fmt.Errorf("failed to get PF GUID: %v", err)
+		}
+		logger.Info("read PF GUID", "hca", hca, "guid", pfGUID)
+		if err := assignVFGuids(hca, pfGUID, numVFs, dryRun); err != nil {
+			return fmt.Errorf("failed to assign VF GUIDs: %v", err)
+		}
+	} else {
+		pfMAC, err := getPFMac(hca)
+		if err != nil {
+			return fmt.Errorf("failed to get PF MAC: %v", err)
+		}
+		logger.Info("read PF MAC", "hca", hca, "mac", pfMAC)
+		if err := assignVFMacs(hca, pfMAC, numVFs, policy.MAC.Strategy, dryRun); err != nil {
+			return fmt.Errorf("failed to assign VF MACs: %v", err)
+		}
+	}
+
+	if policy.MTU > 0 {
+		if err := reconcilePFMTU(hca, policy.MTU, dryRun); err != nil {
+			logger.Warn("failed to set MTU", "hca", hca, "error", err)
+			metrics.configureErrors.inc(hca, "mtu")
+		}
+	}
+
+	if policy.Trust != nil || policy.Spoofchk != nil {
+		if err := reconcileVFAttrs(hca, numVFs, policy.Trust, policy.Spoofchk, dryRun); err != nil {
+			logger.Warn("failed to reconcile VF trust/spoofchk", "hca", hca, "error", err)
+			metrics.configureErrors.inc(hca, "trust_spoofchk")
+		}
+	}
+
+	if switchdevActive && !dryRun {
+		if err := printRepresentorMapping(hca, numVFs); err != nil {
+			logger.Warn("failed to map VF representors", "hca", hca, "error", err)
+			metrics.configureErrors.inc(hca, "representors")
+		}
+	}
+
+	vfDriver := policy.VFDriver
+	if vfDriver == "" {
+		if pciAddr, err := getPFPCIAddr(hca); err == nil {
+			if provider, err := vendor.For(pciAddr); err == nil {
+				vfDriver = provider.RebindPolicy()
+			}
+		}
+	}
+
+	// Unbind and rebind VF devices so that the node_guid is reinitialized
+	// (subject to the REBIND policy) and, if VF_DRIVER is set (directly or
+	// via the PF vendor's default RebindPolicy), so they end up bound to
+	// it.
+	if err := rebindVFDevices(hca, vfDriver, dryRun); err != nil {
+		return fmt.Errorf("failed to rebind VF devices: %v", err)
+	}
+
+	return nil
+}
+
+// resolveLinkLayer returns linkType if set ("ib"/"infiniband" or
+// "eth"/"ethernet", case-insensitive), otherwise autodetects it from hca's
+// port 1.
+func resolveLinkLayer(hca, linkType string) (string, error) {
+	switch strings.ToLower(linkType) {
+	case "":
+		return getLinkLayer(hca)
+	case "ib", "infiniband":
+		return "InfiniBand", nil
+	case "eth", "ethernet":
+		return "Ethernet", nil
+	default:
+		return "", fmt.Errorf("unknown linkType %q", linkType)
+	}
+}
+
+// reconcileSriovNumVFs brings sriov_numvfs to numVFs, skipping the
+// reset/set dance entirely when it is already correct.
+func reconcileSriovNumVFs(hca string, numVFs int, dryRun bool) error {
+	current, err := readCurrentNumVFs(hca)
+	if err != nil {
+		return fmt.Errorf("failed to read current sriov_numvfs: %v", err)
+	}
+	if current == numVFs {
+		logger.Info("sriov_numvfs already correct, skipping", "hca", hca, "numVFs", numVFs)
+		return nil
+	}
+	if dryRun {
+		logger.Info("DRY-RUN: would reconcile sriov_numvfs", "hca", hca, "current", current, "desired", numVFs)
+		return nil
+	}
+	// Reset VF count by writing 0, then set the desired number.
+	if err := setSriovNumVFs(hca, 0); err != nil {
+		return fmt.Errorf("failed to reset sriov_numvfs: %v", err)
+	}
+	if err := setSriovNumVFs(hca, numVFs); err != nil {
+		return fmt.Errorf("failed to set sriov_numvfs to %d: %v", numVFs, err)
+	}
+	return nil
+}
+
+// readCurrentNumVFs reads the number of VFs currently enabled on hca's PF.
+func readCurrentNumVFs(hca string) (int, error) {
+	return readNumVFsAt(filepath.Join(infinibandBasePath, hca, "device", "sriov_numvfs"))
+}
+
+// readNumVFsAt reads the number of VFs currently enabled at sriovPath (a
+// "sriov_numvfs" sysfs file), treating a missing or empty path as zero -
+// e.g. for vendor-specific PFs whose Provider.NumVFsPath is "" because
+// they create VFs/SFs individually rather than by count.
+func readNumVFsAt(sriovPath string) (int, error) {
+	if sriovPath == "" {
+		return 0, nil
+	}
+	data, err := os.ReadFile(sriovPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid sriov_numvfs contents %q: %v", string(data), err)
+	}
+	return n, nil
+}
+
+// reconcilePFMTU sets hca's PF netdev MTU if it differs from mtu.
+func reconcilePFMTU(hca string, mtu int, dryRun bool) error {
+	pfNetdev, err := getPFNetdevName(hca)
+	if err != nil {
+		return err
+	}
+	mtuPath := filepath.Join("/sys/class/net", pfNetdev, "mtu")
+	changed, err := reconcileWrite(mtuPath, strconv.Itoa(mtu), dryRun)
+	if err != nil {
+		return fmt.Errorf("failed to write MTU to %s: %v", mtuPath, err)
+	}
+	if changed && !dryRun {
+		logger.Info("PF MTU set", "hca", hca, "netdev", pfNetdev, "mtu", mtu)
+	}
+	return nil
+}
+
+// reconcileVFAttrs applies the trust and spoofchk policy knobs to every VF
+// of hca, skipping attributes left unset (nil) in policy.
+func reconcileVFAttrs(hca string, numVFs int, trust, spoofchk *bool, dryRun bool) error {
+	for i := 0; i < numVFs; i++ {
+		sriovDir := filepath.Join(infinibandBasePath, hca, "device", "sriov", strconv.Itoa(i))
+		if trust != nil {
+			if _, err := reconcileWrite(filepath.Join(sriovDir, "trust"), onOff(*trust), dryRun); err != nil {
+				logger.Warn("failed to set VF trust", "hca", hca, "vfIndex", i, "error", err)
+			}
+		}
+		if spoofchk != nil {
+			if _, err := reconcileWrite(filepath.Join(sriovDir, "spoofchk"), onOff(*spoofchk), dryRun); err != nil {
+				logger.Warn("failed to set VF spoofchk", "hca", hca, "vfIndex", i, "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+// onOff renders a bool the way mlx5's per-VF sriov sysfs attributes
+// (trust, spoofchk) expect it.
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+// configureEswitchMode applies mode (default "legacy", i.e. no change,
+// falling back to the ESWITCH_MODE env var when mode is empty) to the PF
+// ahead of VF creation. It reports whether switchdev mode is now active
+// so callers can skip representor discovery otherwise.
+func configureEswitchMode(hca, mode string, dryRun bool) (bool, error) {
+	if mode == "" {
+		mode = os.Getenv(eswitchModeEnv)
+	}
+	if mode == "" {
+		mode = eswitchModeLegacy
+	}
+	if mode == eswitchModeLegacy {
+		return false, nil
+	}
+	if mode != eswitchModeSwitchdev {
+		return false, fmt.Errorf("unknown %s value %q", eswitchModeEnv, mode)
+	}
+
+	pfNetdev, err := getPFNetdevName(hca)
+	if err != nil {
+		return false, fmt.Errorf("failed to get PF netdev: %v", err)
+	}
+
+	devlinkModePath := filepath.Join("/sys/class/net", pfNetdev, "compat/devlink/mode")
+	if current, err := os.ReadFile(devlinkModePath); err == nil && strings.TrimSpace(string(current)) == eswitchModeSwitchdev {
+		logger.Info("PF already in switchdev mode, skipping", "hca", hca, "netdev", pfNetdev)
+	} else if dryRun {
+		logger.Info("DRY-RUN: would unbind existing VFs and enable switchdev", "hca", hca, "path", devlinkModePath)
+	} else {
+		// Switching eswitch mode fails while VFs are bound, so make sure
+		// none are left over from a previous run.
+		if err := unbindAllVFs(hca); err != nil {
+			logger.Warn("failed to unbind existing VFs before switchdev", "hca", hca, "error", err)
+		}
+		if err := os.WriteFile(devlinkModePath, []byte(eswitchModeSwitchdev), 0644); err != nil {
+			return false, fmt.Errorf("failed to write %s to %s: %v", eswitchModeSwitchdev, devlinkModePath, err)
+		}
+		logger.Info("PF eswitch mode set to switchdev", "hca", hca, "netdev", pfNetdev)
+	}
+
+	if encapMode := os.Getenv(encapModeEnv); encapMode != "" {
+		encapPath := filepath.Join("/sys/class/net", pfNetdev, "compat/devlink/encap-mode")
+		encapChanged, err := reconcileWrite(encapPath, encapMode, dryRun)
+		if err != nil {
+			logger.Warn("failed to write encap mode", "hca", hca, "encapMode", encapMode, "path", encapPath, "error", err)
+		} else if encapChanged {
+			logger.Info("PF encap mode set", "hca", hca, "netdev", pfNetdev, "encapMode", encapMode)
+		}
+	}
+
+	return true, nil
+}
+
+// unbindAllVFs unbinds every VF of hca's PF from whatever driver it is
+// currently bound to, leaving them driverless so an eswitch mode change
+// can proceed.
+func unbindAllVFs(hca string) error {
+	pciAddrs, err := getVFPCIAddrs(hca)
+	if err != nil {
+		return err
+	}
+	for _, pciAddr := range pciAddrs {
+		driverPath := filepath.Join(pciDevicesBasePath, pciAddr, "driver")
+		driverLink, err := os.Readlink(driverPath)
+		if err != nil {
+			continue // not currently bound
+		}
+		unbindPath := filepath.Join("/sys/bus/pci/drivers", filepath.Base(driverLink), "unbind")
+		if err := os.WriteFile(unbindPath, []byte(pciAddr), 0644); err != nil {
+			logger.Warn("failed to unbind VF", "pci", pciAddr, "error", err)
+		}
+	}
+	return nil
+}
+
+// getVFPCIAddrs resolves the PCI address of every VF of the given HCA by
+// following the PF device directory's "virtfn*" symlinks, ordered by VF
+// index.
+func getVFPCIAddrs(hca string) ([]string, error) {
+	pfDeviceDir := filepath.Join(infinibandBasePath, hca, "device")
+	entries, err := os.ReadDir(pfDeviceDir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading PF device directory %s: %v", pfDeviceDir, err)
 	}
-	numVFs, err := strconv.Atoi(numVFsStr)
-	if err != nil || numVFs <= 0 {
-		fmt.Fprintf(os.Stderr, "Invalid NUM_VFS value: %s\n", numVFsStr)
-		os.Exit(1)
+
+	addrs := make(map[int]string)
+	maxIndex := -1
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), "virtfn") {
+			continue
+		}
+		idx, err := strconv.Atoi(strings.TrimPrefix(entry.Name(), "virtfn"))
+		if err != nil {
+			continue
+		}
+		virtfnPath := filepath.Join(pfDeviceDir, entry.Name())
+		target, err := os.Readlink(virtfnPath)
+		if err != nil {
+			continue
+		}
+		absTarget, err := filepath.Abs(filepath.Join(pfDeviceDir, target))
+		if err != nil {
+			continue
+		}
+		addrs[idx] = filepath.Base(absTarget)
+		if idx > maxIndex {
+			maxIndex = idx
+		}
 	}
 
-	// Optional: if set, only configure HCAs whose device ID matches DEVICE_ID.
-	deviceID := os.Getenv("DEVICE_ID")
+	ordered := make([]string, 0, len(addrs))
+	for i := 0; i <= maxIndex; i++ {
+		if addr, ok := addrs[i]; ok {
+			ordered = append(ordered, addr)
+		}
+	}
+	return ordered, nil
+}
 
-	// Retrieve all HCA entries from infinibandBasePath.
-	hcas, err := getHCAs(infinibandBasePath)
+// printRepresentorMapping waits for switchdev representor netdevs to
+// appear and prints, for each VF, its PCI address, representor name, and
+// VF netdev (if already present).
+func printRepresentorMapping(hca string, numVFs int) error {
+	pciAddrs, err := getVFPCIAddrs(hca)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading HCAs: %v\n", err)
-		os.Exit(1)
+		return err
 	}
-	if len(hcas) == 0 {
-		fmt.Fprintf(os.Stderr, "No HCAs found in %s\n", infinibandBasePath)
-		os.Exit(1)
+	pfPCIAddr, err := getPFPCIAddr(hca)
+	if err != nil {
+		return err
 	}
 
-	// Process each HCA.
-	for _, hca := range hcas {
-		if deviceID != "" {
-			ok, err := checkDeviceId(hca, deviceID)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error checking device ID for %s: %v\n", hca, err)
-				continue
-			}
-			if !ok {
-				fmt.Printf("Skipping HCA %s (device ID mismatch)\n", hca)
-				continue
-			}
+	var representors map[int]string
+	for attempt := 0; attempt < representorWaitAttempts; attempt++ {
+		representors = discoverRepresentors(pfPCIAddr, numVFs)
+		if len(representors) >= numVFs {
+			break
 		}
+		time.Sleep(representorWaitInterval)
+	}
 
-		fmt.Printf("Configuring HCA: %s\n", hca)
-		if err := configureHCA(hca, numVFs); err != nil {
-			fmt.Fprintf(os.Stderr, "Error configuring HCA %s: %v\n", hca, err)
-			continue
+	for i, pciAddr := range pciAddrs {
+		repName := representors[i]
+		if repName == "" {
+			repName = "<not found>"
+		}
+		vfNetdev, err := getNetdevForPCI(pciAddr)
+		if err != nil {
+			vfNetdev = "<none>"
 		}
+		logger.Info("VF representor mapping", "hca", hca, "vfIndex", i, "pci", pciAddr, "representor", repName, "netdev", vfNetdev)
 	}
-
-	fmt.Println("SR-IOV VF configuration completed for all matching HCAs.")
+	return nil
 }
 
-// getHCAs lists all entries in infinibandBasePath and, for each,
-// uses os.Stat to follow symlinks and verify the target is a directory.
-func getHCAs(basePath string) ([]string, error) {
-	entries, err := os.ReadDir(basePath)
+// discoverRepresentors scans /sys/class/net for representor netdevs whose
+// phys_port_name matches "pf<n>vf<m>" and whose device symlink resolves to
+// pfPCIAddr, and returns a map of VF index to representor netdev name.
+// Filtering on the owning PF's PCI address (rather than the "pf<n>" index
+// alone) keeps representors from different HCAs from colliding in the map
+// on a multi-HCA host, since VF index ranges routinely overlap across PFs.
+func discoverRepresentors(pfPCIAddr string, numVFs int) map[int]string {
+	representors := make(map[int]string)
+	entries, err := os.ReadDir("/sys/class/net")
 	if err != nil {
-		return nil, err
+		return representors
 	}
-
-	var hcas []string
 	for _, entry := range entries {
-		fullPath := filepath.Join(basePath, entry.Name())
-		info, err := os.Stat(fullPath) // follows symlinks
+		netDir := filepath.Join("/sys/class/net", entry.Name())
+		portNamePath := filepath.Join(netDir, "phys_port_name")
+		data, err := os.ReadFile(portNamePath)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: could not stat %s: %v\n", fullPath, err)
 			continue
 		}
-		if info.IsDir() {
-			hcas = append(hcas, entry.Name())
+		var pf, vf int
+		if _, err := fmt.Sscanf(strings.TrimSpace(string(data)), "pf%dvf%d", &pf, &vf); err != nil {
+			continue
+		}
+		if vf < 0 || vf >= numVFs {
+			continue
+		}
+		target, err := filepath.EvalSymlinks(filepath.Join(netDir, "device"))
+		if err != nil || filepath.Base(target) != pfPCIAddr {
+			continue
 		}
+		representors[vf] = entry.Name()
 	}
-	return hcas, nil
+	return representors
 }
 
-// checkDeviceId reads the device ID for the given HCA and compares it to expectedDeviceID.
-func checkDeviceId(hca, expectedDeviceID string) (bool, error) {
-	devicePath := filepath.Join(infinibandBasePath, hca, "device", "device")
-	data, err := os.ReadFile(devicePath)
-	if err != nil {
-		return false, err
-	}
-	id := strings.TrimSpace(string(data))
-	return id == expectedDeviceID, nil
+// getNetdevForPCI returns the name of the network interface bound to the
+// given PCI device, if any.
+func getNetdevForPCI(pciAddr string) (string, error) {
+	return getNetdevAt(filepath.Join(pciDevicesBasePath, pciAddr))
 }
 
-// configureHCA performs the VF configuration for a single HCA.
-func configureHCA(hca string, numVFs int) error {
-	// Get the PF MAC address from the first network interface.
-	pfMAC, err := getPFMac(hca)
+// getNetdevAt returns the name of the network interface bound to the
+// device at devDir, if any. devDir need not be on the PCI bus - it works
+// equally for a BlueField SF's auxiliary-bus device directory.
+func getNetdevAt(devDir string) (string, error) {
+	netDir := filepath.Join(devDir, "net")
+	entries, err := os.ReadDir(netDir)
 	if err != nil {
-		return fmt.Errorf("failed to get PF MAC: %v", err)
-	}
-	fmt.Printf("HCA %s PF MAC: %s\n", hca, pfMAC)
-
-	// Reset VF count by writing 0, then set the desired number.
-	if err := setSriovNumVFs(hca, 0); err != nil {
-		return fmt.Errorf("failed to reset sriov_numvfs: %v", err)
+		return "", err
 	}
-	if err := setSriovNumVFs(hca, numVFs); err != nil {
-		return fmt.Errorf("failed to set sriov_numvfs to %d: %v", numVFs, err)
+	if len(entries) == 0 {
+		return "", fmt.Errorf("no network interfaces found in %s", netDir)
 	}
+	return entries[0].Name(), nil
+}
 
-	// Assign MAC addresses to each VF.
-	if err := assignVFMacs(hca, pfMAC, numVFs); err != nil {
-		return fmt.Errorf("failed to assign VF MACs: %v", err)
+// getLinkLayer reads the link layer ("Ethernet" or "InfiniBand") reported
+// by port 1 of the given HCA.
+func getLinkLayer(hca string) (string, error) {
+	linkLayerPath := filepath.Join(infinibandBasePath, hca, "ports", "1", "link_layer")
+	data, err := os.ReadFile(linkLayerPath)
+	if err != nil {
+		return "", fmt.Errorf("error reading link layer from %s: %v", linkLayerPath, err)
 	}
+	return strings.TrimSpace(string(data)), nil
+}
 
-	// Unbind and rebind VF devices so that the node_guid is reinitialized.
-	if err := rebindVFDevices(hca); err != nil {
-		return fmt.Errorf("failed to rebind VF devices: %v", err)
+// getPFGUID reads the PF's node GUID.
+func getPFGUID(hca string) (string, error) {
+	guidPath := filepath.Join(infinibandBasePath, hca, "node_guid")
+	data, err := os.ReadFile(guidPath)
+	if err != nil {
+		return "", fmt.Errorf("error reading node GUID from %s: %v", guidPath, err)
 	}
-
-	return nil
+	return strings.TrimSpace(string(data)), nil
 }
 
-// getPFMac locates the first network interface under
-// /sys/class/infiniband/<hca>/device/net/ and returns its MAC address.
-func getPFMac(hca string) (string, error) {
+// getPFNetdevName locates the first network interface under
+// /sys/class/infiniband/<hca>/device/net/.
+func getPFNetdevName(hca string) (string, error) {
 	netDir := filepath.Join(infinibandBasePath, hca, "device", "net")
 	entries, err := os.ReadDir(netDir)
 	if err != nil {
@@ -137,8 +1256,32 @@ func getPFMac(hca string) (string, error) {
 		return "", fmt.Errorf("no network interfaces found in %s", netDir)
 	}
 	// Use the first interface found.
-	iface := entries[0].Name()
-	addrPath := filepath.Join(netDir, iface, "address")
+	return entries[0].Name(), nil
+}
+
+// getPFMac returns the MAC address of the PF's network interface.
+func getPFMac(hca string) (string, error) {
+	iface, err := getPFNetdevName(hca)
+	if err != nil {
+		return "", err
+	}
+	addrPath := filepath.Join(infinibandBasePath, hca, "device", "net", iface, "address")
+	data, err := os.ReadFile(addrPath)
+	if err != nil {
+		return "", fmt.Errorf("error reading MAC address from %s: %v", addrPath, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// getPCIPFMac returns the MAC address of pciAddr's network interface.
+// Used for vendor-specific PFs discovered outside the infiniband flow,
+// where getPFMac's hca-keyed path doesn't apply.
+func getPCIPFMac(pciAddr string) (string, error) {
+	iface, err := getNetdevForPCI(pciAddr)
+	if err != nil {
+		return "", err
+	}
+	addrPath := filepath.Join(pciDevicesBasePath, pciAddr, "net", iface, "address")
 	data, err := os.ReadFile(addrPath)
 	if err != nil {
 		return "", fmt.Errorf("error reading MAC address from %s: %v", addrPath, err)
@@ -152,91 +1295,475 @@ func setSriovNumVFs(hca string, num int) error {
 	return os.WriteFile(sriovPath, []byte(strconv.Itoa(num)), 0644)
 }
 
-// assignVFMacs derives and assigns a MAC address to each VF based on the PF MAC.
-// The new MAC is built by replacing the first octet with "02" (locally administered)
-// and offsetting the last octet by the VF index.
-func assignVFMacs(hca, pfMAC string, numVFs int) error {
+// assignVFMacs derives a MAC address for each VF according to strategy
+// (default "offset" when empty) and writes it to sysfs, skipping VFs that
+// already have the desired MAC.
+func assignVFMacs(hca, pfMAC string, numVFs int, strategy string, dryRun bool) error {
+	if strategy == "" {
+		strategy = macStrategyOffset
+	}
+
+	var explicitMACs map[string]string
+	if strategy == macStrategyExplicit {
+		var err error
+		explicitMACs, err = loadExplicitMACMap()
+		if err != nil {
+			return fmt.Errorf("failed to load explicit VF MAC map: %v", err)
+		}
+	}
+
+	for i := 0; i < numVFs; i++ {
+		var vfMAC string
+		var err error
+		switch strategy {
+		case macStrategyOffset:
+			vfMAC, err = deriveOffsetMAC(pfMAC, i)
+		case macStrategyHash:
+			vfMAC, err = deriveHashMAC(hca, i)
+		case macStrategyExplicit:
+			vfMAC, err = lookupExplicitMAC(explicitMACs, hca, i)
+		default:
+			return fmt.Errorf("unknown %s value %q", macStrategyEnv, strategy)
+		}
+		if err != nil {
+			logger.Warn("failed to derive VF MAC", "hca", hca, "vfIndex", i, "error", err)
+			continue
+		}
+
+		vfMacPath := filepath.Join(infinibandBasePath, hca, "device", "sriov", strconv.Itoa(i), "mac")
+		changed, err := reconcileWrite(vfMacPath, vfMAC, dryRun)
+		if err != nil {
+			logger.Warn("failed to write VF MAC", "hca", hca, "vfIndex", i, "path", vfMacPath, "error", err)
+			continue
+		}
+		if changed {
+			logger.Info("assigned VF MAC", "hca", hca, "vfIndex", i, "mac", vfMAC)
+			metrics.vfConfigured.inc(hca)
+		} else {
+			logger.Info("VF MAC already correct, skipping", "hca", hca, "vfIndex", i, "mac", vfMAC)
+		}
+	}
+	return nil
+}
+
+// deriveOffsetMAC is the original strategy: replace the first octet with
+// "02" (locally administered) and offset the last octet by the VF index.
+// It wraps (and collides) once more than 256 VFs share a PF MAC prefix.
+func deriveOffsetMAC(pfMAC string, vfIndex int) (string, error) {
 	octets := strings.Split(pfMAC, ":")
 	if len(octets) != 6 {
-		return fmt.Errorf("invalid PF MAC address format: %s", pfMAC)
+		return "", fmt.Errorf("invalid PF MAC address format: %s", pfMAC)
 	}
-	newFirstOctet := "02"
 
-	// Parse the PF's last octet.
 	pfLastOctet, err := strconv.ParseInt(octets[5], 16, 64)
 	if err != nil {
-		return fmt.Errorf("invalid last octet in PF MAC %s: %v", octets[5], err)
+		return "", fmt.Errorf("invalid last octet in PF MAC %s: %v", octets[5], err)
+	}
+
+	newLastOctetVal := (pfLastOctet + int64(vfIndex)) % 256
+	return fmt.Sprintf("02:%s:%s:%s:%s:%02x", octets[1], octets[2], octets[3], octets[4], newLastOctetVal), nil
+}
+
+// deriveHashMAC derives a collision-resistant VF MAC from
+// SHA-256(hostname|hca|vfIndex). The top byte is forced to 0x02 so the
+// result is always locally administered and unicast, regardless of host
+// count or VF index.
+func deriveHashMAC(hca string, vfIndex int) (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("failed to get hostname: %v", err)
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d", hostname, hca, vfIndex)))
+	return fmt.Sprintf("02:%02x:%02x:%02x:%02x:%02x", sum[0], sum[1], sum[2], sum[3], sum[4]), nil
+}
+
+// loadExplicitMACMap loads a per-HCA/VF MAC assignment table from either
+// the VF_MAC_MAP environment variable (inline JSON) or the file named by
+// VF_MAC_MAP_FILE. Keys are "<hca>/<vfIndex>" (e.g. "mlx5_0/3") or a bare
+// "<vfIndex>" shared across HCAs (see lookupExplicitMAC).
+func loadExplicitMACMap() (map[string]string, error) {
+	var data []byte
+	if inline := os.Getenv("VF_MAC_MAP"); inline != "" {
+		data = []byte(inline)
+	} else if path := os.Getenv("VF_MAC_MAP_FILE"); path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", path, err)
+		}
+	} else {
+		return nil, fmt.Errorf("MAC_STRATEGY=%s requires VF_MAC_MAP or VF_MAC_MAP_FILE", macStrategyExplicit)
+	}
+
+	macMap := make(map[string]string)
+	if err := json.Unmarshal(data, &macMap); err != nil {
+		return nil, fmt.Errorf("invalid VF MAC map JSON: %v", err)
+	}
+	return macMap, nil
+}
+
+// lookupExplicitMAC resolves the MAC for a given HCA/VF from an explicit
+// map, falling back to a bare VF-index key shared across HCAs.
+func lookupExplicitMAC(macMap map[string]string, hca string, vfIndex int) (string, error) {
+	key := fmt.Sprintf("%s/%d", hca, vfIndex)
+	if mac, ok := macMap[key]; ok {
+		return mac, nil
+	}
+	if mac, ok := macMap[strconv.Itoa(vfIndex)]; ok {
+		return mac, nil
+	}
+	return "", fmt.Errorf("no explicit MAC found for %s", key)
+}
+
+// assignVFGuids derives and assigns a node/port GUID to each VF of an
+// InfiniBand HCA. The EUI-64 is built from the PF's node GUID with the
+// low byte offset by the VF index, mirroring deriveOffsetMAC. VFs already
+// at the desired GUID are left untouched.
+func assignVFGuids(hca, pfGUID string, numVFs int, dryRun bool) error {
+	groups := strings.Split(pfGUID, ":")
+	if len(groups) != 8 {
+		return fmt.Errorf("invalid PF GUID format: %s", pfGUID)
+	}
+
+	pfLastGroup, err := strconv.ParseInt(groups[7], 16, 64)
+	if err != nil {
+		return fmt.Errorf("invalid last group in PF GUID %s: %v", groups[7], err)
 	}
 
 	for i := 0; i < numVFs; i++ {
-		newLastOctetVal := (pfLastOctet + int64(i)) % 256
-		newLastOctet := fmt.Sprintf("%02x", newLastOctetVal)
-		vfMAC := fmt.Sprintf("%s:%s:%s:%s:%s:%s", newFirstOctet, octets[1], octets[2], octets[3], octets[4], newLastOctet)
+		newLastGroupVal := (pfLastGroup + int64(i)) % 256
+		groups[7] = fmt.Sprintf("%02x", newLastGroupVal)
+		vfGUID := strings.Join(groups, ":")
 
-		vfMacPath := filepath.Join(infinibandBasePath, hca, "device", "sriov", strconv.Itoa(i), "mac")
-		if err := os.WriteFile(vfMacPath, []byte(vfMAC), 0644); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to write VF %d MAC to %s: %v\n", i, vfMacPath, err)
-			continue
+		sriovDir := filepath.Join(infinibandBasePath, hca, "device", "sriov", strconv.Itoa(i))
+		anyChanged := false
+		for _, guidFile := range []string{"node_guid", "port_guid"} {
+			guidPath := filepath.Join(sriovDir, guidFile)
+			changed, err := reconcileWrite(guidPath, vfGUID, dryRun)
+			if err != nil {
+				logger.Warn("failed to write VF GUID attribute", "hca", hca, "vfIndex", i, "attr", guidFile, "path", guidPath, "error", err)
+				continue
+			}
+			anyChanged = anyChanged || changed
+		}
+		if anyChanged {
+			logger.Info("assigned VF GUID", "hca", hca, "vfIndex", i, "guid", vfGUID)
+			metrics.vfConfigured.inc(hca)
+		} else {
+			logger.Info("VF GUID already correct, skipping", "hca", hca, "vfIndex", i, "guid", vfGUID)
 		}
-		fmt.Printf("HCA %s: Assigned VF %d MAC: %s\n", hca, i, vfMAC)
 	}
 	return nil
 }
 
-// rebindVFDevices unbinds and then rebinds each VF device associated with the given HCA.
-// It finds VF PCI devices by reading symlinks named "virtfn*" under the PF's PCI directory.
-func rebindVFDevices(hca string) error {
-	// The PF's PCI directory is at /sys/class/infiniband/<hca>/device.
-	pfDeviceDir := filepath.Join(infinibandBasePath, hca, "device")
-	entries, err := os.ReadDir(pfDeviceDir)
+// vfRebindReport records the outcome of (re)binding a single VF's driver.
+type vfRebindReport struct {
+	PCI       string `json:"pci"`
+	OldDriver string `json:"old_driver"`
+	NewDriver string `json:"new_driver"`
+}
+
+// rebindVFDevices (re)binds each VF device associated with the given HCA
+// according to the REBIND env policy (default "auto", i.e. only when RDMA
+// is in use, mirroring sriov-network-operator's "reload VF driver only
+// when rdma is enabled"). If vfDriver is set (from the policy, falling
+// back to VF_DRIVER), VFs are bound to that driver instead of their
+// current one, via driver_override - this is how VFs get handed to
+// vfio-pci/uio_pci_generic for DPDK/userspace workloads. An explicit
+// vfDriver forces the rebind under REBIND=auto, since the operator has
+// stated where the VF belongs regardless of whether RDMA happens to be in
+// use - this matters most on a pure DPDK/VFIO host with no ib_core loaded,
+// where the "auto" RDMA check would otherwise never fire. REBIND=never
+// still skips the rebind (with a warning) even when vfDriver is set. VFs
+// already bound to vfDriver are left untouched.
+func rebindVFDevices(hca, vfDriver string, dryRun bool) error {
+	targetDriver := vfDriver
+	if targetDriver == "" {
+		targetDriver = os.Getenv(vfDriverEnv)
+	}
+
+	policy := os.Getenv(rebindPolicyEnv)
+	if policy == "" {
+		policy = rebindPolicyAuto
+	}
+
+	switch policy {
+	case rebindPolicyNever:
+		if targetDriver != "" {
+			logger.Warn("VF_DRIVER is set but REBIND=never is short-circuiting it, VF driver will not be applied", "hca", hca, "driver", targetDriver)
+		}
+		logger.Info("skipping VF rebind", "hca", hca, rebindPolicyEnv, policy)
+		return nil
+	case rebindPolicyAuto:
+		if targetDriver == "" {
+			inUse, err := rdmaInUse()
+			if err != nil {
+				logger.Warn("failed to detect RDMA usage, skipping VF rebind", "hca", hca, "error", err)
+				return nil
+			}
+			if !inUse {
+				logger.Info("skipping VF rebind, RDMA not in use", "hca", hca)
+				return nil
+			}
+		}
+	case rebindPolicyAlways:
+		// Fall through to rebind unconditionally.
+	default:
+		return fmt.Errorf("unknown %s value %q", rebindPolicyEnv, policy)
+	}
+
+	pciAddrs, err := getVFPCIAddrs(hca)
 	if err != nil {
-		return fmt.Errorf("error reading PF device directory %s: %v", pfDeviceDir, err)
+		return err
 	}
-	for _, entry := range entries {
-		if !strings.HasPrefix(entry.Name(), "virtfn") {
+	for i, pciAddr := range pciAddrs {
+		if targetDriver != "" {
+			if current, ok := currentDriver(pciAddr); ok && current == targetDriver {
+				logger.Info("VF already bound to target driver, skipping", "hca", hca, "pci", pciAddr, "driver", targetDriver)
+				metrics.vfDriverInfo.set(pciAddr, targetDriver, hca, strconv.Itoa(i))
+				continue
+			}
+		}
+		if dryRun {
+			logger.Info("DRY-RUN: would rebind VF", "hca", hca, "pci", pciAddr, "driver", targetDriver)
 			continue
 		}
-		virtfnPath := filepath.Join(pfDeviceDir, entry.Name())
-		// Resolve the symlink to get the VF's PCI device directory.
-		target, err := os.Readlink(virtfnPath)
+		report, err := rebindVF(pciAddr, targetDriver)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: could not read symlink %s: %v\n", virtfnPath, err)
+			logger.Warn("failed to rebind VF", "hca", hca, "pci", pciAddr, "error", err)
+			metrics.configureErrors.inc(hca, "rebind")
 			continue
 		}
-		// The target is typically a relative path; resolve it to an absolute path.
-		absTarget, err := filepath.Abs(filepath.Join(pfDeviceDir, target))
+		logger.Info("rebound VF driver", "hca", hca, "pci", report.PCI, "oldDriver", report.OldDriver, "newDriver", report.NewDriver)
+		metrics.vfDriverInfo.set(report.PCI, report.NewDriver, hca, strconv.Itoa(i))
+	}
+	return nil
+}
+
+// currentDriver returns the driver currently bound to pciAddr, if any.
+func currentDriver(pciAddr string) (string, bool) {
+	return currentDriverAt(filepath.Join(pciDevicesBasePath, pciAddr))
+}
+
+// currentDriverAt returns the driver currently bound to the device at
+// devDir, if any. devDir need not be on the PCI bus - it works equally for
+// a BlueField SF's auxiliary-bus device directory.
+func currentDriverAt(devDir string) (string, bool) {
+	driverLink, err := os.Readlink(filepath.Join(devDir, "driver"))
+	if err != nil {
+		return "", false
+	}
+	return filepath.Base(driverLink), true
+}
+
+// rdmaInUse reports whether the ib_core module (and therefore RDMA) is
+// currently loaded.
+func rdmaInUse() (bool, error) {
+	_, err := os.Stat("/sys/module/ib_core")
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// rebindVF unbinds a VF from its current driver (if any) and binds it to
+// targetDriver, or back to its current driver when targetDriver is empty.
+func rebindVF(pciAddr, targetDriver string) (vfRebindReport, error) {
+	return rebindDevice(filepath.Join(pciDevicesBasePath, pciAddr), targetDriver)
+}
+
+// rebindDevice unbinds devDir - an arbitrary bus device directory, e.g.
+// under /sys/bus/pci/devices or /sys/bus/auxiliary/devices - from its
+// current driver and binds it to targetDriver, or back to its current
+// driver when targetDriver is empty. It follows devDir's own "subsystem"
+// symlink rather than assuming PCI, so the same logic rebinds BlueField
+// scalable functions on the auxiliary bus.
+func rebindDevice(devDir, targetDriver string) (vfRebindReport, error) {
+	id := filepath.Base(devDir)
+	report := vfRebindReport{PCI: id, OldDriver: "<none>", NewDriver: "<none>"}
+
+	subsystem, err := filepath.EvalSymlinks(filepath.Join(devDir, "subsystem"))
+	if err != nil {
+		return report, fmt.Errorf("failed to resolve bus for %s: %v", devDir, err)
+	}
+	driversPath := filepath.Join(subsystem, "drivers")
+
+	driverLink, err := os.Readlink(filepath.Join(devDir, "driver"))
+	hasDriver := err == nil
+	if hasDriver {
+		report.OldDriver = filepath.Base(driverLink)
+		unbindPath := filepath.Join(driversPath, report.OldDriver, "unbind")
+		if err := os.WriteFile(unbindPath, []byte(id), 0644); err != nil {
+			return report, fmt.Errorf("failed to unbind from %s: %v", report.OldDriver, err)
+		}
+	}
+
+	newDriver := targetDriver
+	if newDriver == "" {
+		if !hasDriver {
+			return report, fmt.Errorf("no driver currently bound and %s is not set", vfDriverEnv)
+		}
+		newDriver = report.OldDriver
+	}
+	report.NewDriver = newDriver
+
+	if targetDriver != "" {
+		overridePath := filepath.Join(devDir, "driver_override")
+		if err := os.WriteFile(overridePath, []byte(targetDriver), 0644); err != nil {
+			return report, fmt.Errorf("failed to set driver_override to %s: %v", targetDriver, err)
+		}
+	}
+
+	bindPath := filepath.Join(driversPath, newDriver, "bind")
+	if err := os.WriteFile(bindPath, []byte(id), 0644); err != nil {
+		return report, fmt.Errorf("failed to bind to %s: %v", newDriver, err)
+	}
+	return report, nil
+}
+
+// vfStatus is a single VF's reported state for --status output.
+type vfStatus struct {
+	Index       int    `json:"index"`
+	PCI         string `json:"pci"`
+	Driver      string `json:"driver,omitempty"`
+	Netdev      string `json:"netdev,omitempty"`
+	Representor string `json:"representor,omitempty"`
+	MACOrGUID   string `json:"macOrGuid,omitempty"`
+}
+
+// hcaStatus is a single HCA's reported state for --status output.
+type hcaStatus struct {
+	HCA          string     `json:"hca"`
+	PCI          string     `json:"pci"`
+	VendorDevice string     `json:"vendorDevice"`
+	LinkLayer    string     `json:"linkLayer,omitempty"`
+	MTU          int        `json:"mtu,omitempty"`
+	NumVFs       int        `json:"numVFs"`
+	VFs          []vfStatus `json:"vfs"`
+}
+
+// printStatus prints the current state of every HCA (and its VFs) as a
+// JSON array, without changing anything.
+func printStatus(hcas []string) error {
+	statuses := make([]hcaStatus, 0, len(hcas))
+	for _, hca := range hcas {
+		status := hcaStatus{HCA: hca}
+
+		if pciAddr, err := getPFPCIAddr(hca); err == nil {
+			status.PCI = pciAddr
+		}
+		if vd, err := getVendorDevice(hca); err == nil {
+			status.VendorDevice = vd
+		}
+		if linkLayer, err := getLinkLayer(hca); err == nil {
+			status.LinkLayer = linkLayer
+		}
+		if pfNetdev, err := getPFNetdevName(hca); err == nil {
+			if data, err := os.ReadFile(filepath.Join("/sys/class/net", pfNetdev, "mtu")); err == nil {
+				if mtu, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+					status.MTU = mtu
+				}
+			}
+		}
+
+		numVFs, err := readCurrentNumVFs(hca)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: could not resolve absolute path for %s: %v\n", virtfnPath, err)
-			continue
+			logger.Warn("failed to read VF count", "hca", hca, "error", err)
+		}
+		status.NumVFs = numVFs
+
+		pciAddrs, err := getVFPCIAddrs(hca)
+		if err != nil {
+			logger.Warn("failed to enumerate VFs", "hca", hca, "error", err)
+			pciAddrs = nil
+		}
+		representors := discoverRepresentors(status.PCI, numVFs)
+
+		for i, pciAddr := range pciAddrs {
+			vf := vfStatus{Index: i, PCI: pciAddr}
+			if driver, ok := currentDriver(pciAddr); ok {
+				vf.Driver = driver
+			}
+			if netdev, err := getNetdevForPCI(pciAddr); err == nil {
+				vf.Netdev = netdev
+			}
+			if repName, ok := representors[i]; ok {
+				vf.Representor = repName
+			}
+
+			var attr string
+			if strings.EqualFold(status.LinkLayer, "InfiniBand") {
+				attr = "node_guid"
+			} else {
+				attr = "mac"
+			}
+			attrPath := filepath.Join(infinibandBasePath, hca, "device", "sriov", strconv.Itoa(i), attr)
+			if data, err := os.ReadFile(attrPath); err == nil {
+				vf.MACOrGUID = strings.TrimSpace(string(data))
+			}
+
+			status.VFs = append(status.VFs, vf)
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	vendorPFs, err := vendor.DiscoverPFs()
+	if err != nil {
+		logger.Warn("failed to discover vendor-specific PFs", "error", err)
+	}
+	for _, pf := range vendorPFs {
+		status := hcaStatus{PCI: pf}
+		if vd, err := vendor.ReadVendorDevice(pf); err == nil {
+			status.VendorDevice = vd
 		}
-		// The PCI address is the base name of the VF device directory.
-		pciAddr := filepath.Base(absTarget)
-		fmt.Printf("Rebinding VF with PCI address: %s\n", pciAddr)
 
-		// Determine the driver by reading the "driver" symlink in the VF's PCI device directory.
-		vfDriverPath := filepath.Join("/sys/bus/pci/devices", pciAddr, "driver")
-		driverLink, err := os.Readlink(vfDriverPath)
+		provider, err := vendor.For(pf)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: could not read driver symlink for VF %s: %v\n", pciAddr, err)
+			logger.Warn("failed to identify vendor provider", "pci", pf, "error", err)
+			statuses = append(statuses, status)
 			continue
 		}
-		driverName := filepath.Base(driverLink)
 
-		// Unbind the VF.
-		unbindPath := filepath.Join("/sys/bus/pci/drivers", driverName, "unbind")
-		if err := os.WriteFile(unbindPath, []byte(pciAddr), 0644); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to unbind VF %s: %v\n", pciAddr, err)
-		} else {
-			fmt.Printf("Unbound VF %s from driver %s\n", pciAddr, driverName)
+		numVFsPath := provider.NumVFsPath(pf)
+		numVFs, err := readNumVFsAt(numVFsPath)
+		if err != nil {
+			logger.Warn("failed to read VF/SF count", "pci", pf, "error", err)
 		}
+		status.NumVFs = numVFs
 
-		// Bind the VF.
-		bindPath := filepath.Join("/sys/bus/pci/drivers", driverName, "bind")
-		if err := os.WriteFile(bindPath, []byte(pciAddr), 0644); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to bind VF %s: %v\n", pciAddr, err)
-		} else {
-			fmt.Printf("Bound VF %s to driver %s\n", pciAddr, driverName)
+		vfs, err := provider.Discover(pf)
+		if err != nil {
+			logger.Warn("failed to discover VFs/SFs", "pci", pf, "error", err)
+			vfs = nil
 		}
+		for i, vfPath := range vfs {
+			vf := vfStatus{Index: i, PCI: filepath.Base(vfPath)}
+			if driver, ok := currentDriverAt(vfPath); ok {
+				vf.Driver = driver
+			}
+			if netdev, err := getNetdevAt(vfPath); err == nil {
+				vf.Netdev = netdev
+			}
+			if numVFsPath != "" {
+				macPath := filepath.Join(pciDevicesBasePath, pf, "sriov", strconv.Itoa(i), "mac")
+				if data, err := os.ReadFile(macPath); err == nil {
+					vf.MACOrGUID = strings.TrimSpace(string(data))
+				}
+			}
+			status.VFs = append(status.VFs, vf)
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	out, err := json.MarshalIndent(statuses, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal status: %v", err)
 	}
+	fmt.Println(string(out))
 	return nil
 }
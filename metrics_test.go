@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDebounceCoalescesBurst(t *testing.T) {
+	in := make(chan struct{})
+	out := debounce(in, 20*time.Millisecond)
+
+	for i := 0; i < 5; i++ {
+		in <- struct{}{}
+	}
+
+	select {
+	case <-out:
+	case <-time.After(time.Second):
+		t.Fatal("debounce never fired after a burst")
+	}
+
+	select {
+	case <-out:
+		t.Fatal("debounce fired twice for a single burst")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDebounceResetsOnNewEvent(t *testing.T) {
+	in := make(chan struct{})
+	out := debounce(in, 50*time.Millisecond)
+
+	in <- struct{}{}
+	time.Sleep(30 * time.Millisecond)
+	in <- struct{}{} // arrives before the first window would have fired
+
+	// Without the reset the first window would fire ~20ms from now.
+	select {
+	case <-out:
+		t.Fatal("debounce fired on the first event's window despite a newer event")
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	select {
+	case <-out:
+	case <-time.After(time.Second):
+		t.Fatal("debounce never fired after its window")
+	}
+}
+
+func TestLabelSet(t *testing.T) {
+	got := labelSet([]string{"hca", "step"}, "mlx5_0\x00mac")
+	want := `hca="mlx5_0",step="mac"`
+	if got != want {
+		t.Errorf("labelSet = %q, want %q", got, want)
+	}
+}
+
+func TestCounterVecWrite(t *testing.T) {
+	c := newCounterVec("sriov_vf_configured_total", "Total VFs configured.", "hca")
+	c.inc("mlx5_0")
+	c.inc("mlx5_0")
+	c.inc("mlx5_1")
+
+	rec := httptest.NewRecorder()
+	c.write(rec)
+	body := rec.Body.String()
+
+	if !strings.Contains(body, `sriov_vf_configured_total{hca="mlx5_0"} 2`) {
+		t.Errorf("write output missing mlx5_0 counter at 2, got:\n%s", body)
+	}
+	if !strings.Contains(body, `sriov_vf_configured_total{hca="mlx5_1"} 1`) {
+		t.Errorf("write output missing mlx5_1 counter at 1, got:\n%s", body)
+	}
+	if !strings.Contains(body, "# TYPE sriov_vf_configured_total counter") {
+		t.Errorf("write output missing TYPE line, got:\n%s", body)
+	}
+}
+
+func TestInfoVecWrite(t *testing.T) {
+	iv := newInfoVec("sriov_vf_driver_info", "VF driver binding.", "pci", "driver")
+	iv.set("0000:17:00.1", "vfio-pci")
+	iv.set("0000:17:00.1", "vfio-pci") // duplicate set should not double-emit
+
+	rec := httptest.NewRecorder()
+	iv.write(rec)
+	body := rec.Body.String()
+
+	want := `sriov_vf_driver_info{pci="0000:17:00.1",driver="vfio-pci"} 1`
+	if strings.Count(body, want) != 1 {
+		t.Errorf("write output should contain exactly one line %q, got:\n%s", want, body)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPolicyMatchHCAGlob(t *testing.T) {
+	m := PolicyMatch{HCAGlob: "mlx5_*"}
+
+	ok, err := m.matches("mlx5_0", "")
+	if err != nil || !ok {
+		t.Errorf("matches(mlx5_0) = %v, %v, want true, nil", ok, err)
+	}
+	ok, err = m.matches("ib0", "")
+	if err != nil || ok {
+		t.Errorf("matches(ib0) = %v, %v, want false, nil", ok, err)
+	}
+	// HCAGlob never matches a vendor-specific PF with no infiniband HCA.
+	ok, err = m.matches("", "")
+	if err != nil || ok {
+		t.Errorf("matches(\"\") = %v, %v, want false, nil", ok, err)
+	}
+}
+
+func TestPolicyMatchVendorDevice(t *testing.T) {
+	fakePCIDevices := t.TempDir()
+	orig := pciDevicesBasePath
+	pciDevicesBasePath = fakePCIDevices
+	t.Cleanup(func() { pciDevicesBasePath = orig })
+
+	pciAddr := "0000:17:00.0"
+	devDir := filepath.Join(fakePCIDevices, pciAddr)
+	if err := os.MkdirAll(devDir, 0755); err != nil {
+		t.Fatalf("setup MkdirAll: %v", err)
+	}
+	// sysfs reports these with a 0x prefix; PolicyMatch.VendorDevice is
+	// documented (and registered in internal/vendor) without one.
+	if err := os.WriteFile(filepath.Join(devDir, "vendor"), []byte("0x15b3\n"), 0644); err != nil {
+		t.Fatalf("setup WriteFile(vendor): %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(devDir, "device"), []byte("0xa2d6\n"), 0644); err != nil {
+		t.Fatalf("setup WriteFile(device): %v", err)
+	}
+
+	m := PolicyMatch{VendorDevice: "15b3:a2d6"}
+	ok, err := m.matches("", pciAddr)
+	if err != nil || !ok {
+		t.Errorf("matches(%q) = %v, %v, want true, nil", pciAddr, ok, err)
+	}
+
+	m = PolicyMatch{VendorDevice: "0x15b3:0xa2d6"}
+	ok, err = m.matches("", pciAddr)
+	if err != nil || ok {
+		t.Errorf("matches(%q) with 0x-prefixed selector = %v, %v, want false, nil", pciAddr, ok, err)
+	}
+
+	m = PolicyMatch{VendorDevice: "15b3:1017"}
+	ok, err = m.matches("", pciAddr)
+	if err != nil || ok {
+		t.Errorf("matches(%q) with mismatched device = %v, %v, want false, nil", pciAddr, ok, err)
+	}
+}
+
+func TestPolicyMatchPCIPrefix(t *testing.T) {
+	m := PolicyMatch{PCIPrefix: "0000:17:"}
+
+	ok, err := m.matches("", "0000:17:00.1")
+	if err != nil || !ok {
+		t.Errorf("matches(0000:17:00.1) = %v, %v, want true, nil", ok, err)
+	}
+	ok, err = m.matches("", "0000:3b:00.0")
+	if err != nil || ok {
+		t.Errorf("matches(0000:3b:00.0) = %v, %v, want false, nil", ok, err)
+	}
+}
+
+func TestPolicyMatchEmptyMatchesEverything(t *testing.T) {
+	var m PolicyMatch
+	ok, err := m.matches("mlx5_0", "0000:17:00.0")
+	if err != nil || !ok {
+		t.Errorf("empty PolicyMatch.matches = %v, %v, want true, nil", ok, err)
+	}
+}
+
+func TestPolicyMatchInvalidGlob(t *testing.T) {
+	m := PolicyMatch{HCAGlob: "["}
+	if _, err := m.matches("mlx5_0", ""); err == nil {
+		t.Error("expected error for malformed hcaGlob, got nil")
+	}
+}
+
+func TestReconcileWriteSkipsWhenAlreadyCorrect(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "attr")
+	if err := os.WriteFile(path, []byte("value\n"), 0644); err != nil {
+		t.Fatalf("setup WriteFile: %v", err)
+	}
+
+	changed, err := reconcileWrite(path, "value", false)
+	if err != nil {
+		t.Fatalf("reconcileWrite: unexpected error: %v", err)
+	}
+	if changed {
+		t.Error("reconcileWrite reported a change when the value already matched")
+	}
+}
+
+func TestReconcileWriteWritesWhenDifferent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "attr")
+	if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatalf("setup WriteFile: %v", err)
+	}
+
+	changed, err := reconcileWrite(path, "new", false)
+	if err != nil {
+		t.Fatalf("reconcileWrite: unexpected error: %v", err)
+	}
+	if !changed {
+		t.Error("reconcileWrite reported no change when the value differed")
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(got) != "new" {
+		t.Errorf("file contents = %q, want %q", got, "new")
+	}
+}
+
+func TestReconcileWriteDryRunDoesNotWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "attr")
+	if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatalf("setup WriteFile: %v", err)
+	}
+
+	changed, err := reconcileWrite(path, "new", true)
+	if err != nil {
+		t.Fatalf("reconcileWrite: unexpected error: %v", err)
+	}
+	if !changed {
+		t.Error("reconcileWrite (dry-run) reported no change when the value differed")
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(got) != "old\n" {
+		t.Errorf("dry-run reconcileWrite modified the file: got %q, want unchanged %q", got, "old\n")
+	}
+}